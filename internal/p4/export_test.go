@@ -0,0 +1,123 @@
+package p4
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestParseFileType(t *testing.T) {
+	cases := []struct {
+		fileType       string
+		wantSymlink    bool
+		wantExecutable bool
+	}{
+		{"text", false, false},
+		{"binary", false, false},
+		{"text+x", false, true},
+		{"binary+xw", false, true},
+		{"xtext", false, true},
+		{"xbinary", false, true},
+		{"symlink", true, false},
+		{"xtext+k", false, true},
+	}
+
+	for _, c := range cases {
+		gotSymlink, gotExecutable := parseFileType(c.fileType)
+		if gotSymlink != c.wantSymlink || gotExecutable != c.wantExecutable {
+			t.Errorf("parseFileType(%q) = (%v, %v), want (%v, %v)",
+				c.fileType, gotSymlink, gotExecutable, c.wantSymlink, c.wantExecutable)
+		}
+	}
+}
+
+func TestWriteTarEntryRegularFile(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	f := DepotFile{Path: "Engine/foo.cpp", Type: "text"}
+	data := []byte("hello world")
+	if err := writeTarEntry(tw, f, data, ExportOptions{}); err != nil {
+		t.Fatalf("writeTarEntry: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tw.Close: %v", err)
+	}
+
+	tr := tar.NewReader(&buf)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatalf("tr.Next: %v", err)
+	}
+	if hdr.Name != f.Path || hdr.Typeflag != tar.TypeReg || hdr.Mode != 0644 || hdr.Size != int64(len(data)) {
+		t.Fatalf("header = %+v", hdr)
+	}
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(tr, got); err != nil {
+		t.Fatalf("io.ReadFull: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("content = %q, want %q", got, data)
+	}
+}
+
+func TestWriteTarEntryExecutable(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	f := DepotFile{Path: "Engine/run.sh", Type: "text+x"}
+	if err := writeTarEntry(tw, f, []byte("#!/bin/sh"), ExportOptions{}); err != nil {
+		t.Fatalf("writeTarEntry: %v", err)
+	}
+	tw.Close()
+
+	tr := tar.NewReader(&buf)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatalf("tr.Next: %v", err)
+	}
+	if hdr.Mode != 0755 {
+		t.Fatalf("Mode = %o, want 0755", hdr.Mode)
+	}
+}
+
+func TestWriteTarEntryModeOverride(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	f := DepotFile{Path: "Engine/run.sh", Type: "text+x"}
+	if err := writeTarEntry(tw, f, []byte("#!/bin/sh"), ExportOptions{ModeOverride: 0640}); err != nil {
+		t.Fatalf("writeTarEntry: %v", err)
+	}
+	tw.Close()
+
+	tr := tar.NewReader(&buf)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatalf("tr.Next: %v", err)
+	}
+	if hdr.Mode != 0640 {
+		t.Fatalf("Mode = %o, want 0640 (ModeOverride should win over the +x bit)", hdr.Mode)
+	}
+}
+
+func TestWriteTarEntrySymlink(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	f := DepotFile{Path: "Engine/link", Type: "symlink"}
+	if err := writeTarEntry(tw, f, []byte("Engine/target\n"), ExportOptions{}); err != nil {
+		t.Fatalf("writeTarEntry: %v", err)
+	}
+	tw.Close()
+
+	tr := tar.NewReader(&buf)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatalf("tr.Next: %v", err)
+	}
+	if hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "Engine/target" {
+		t.Fatalf("header = %+v", hdr)
+	}
+}
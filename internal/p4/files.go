@@ -10,8 +10,8 @@ func (p *P4) ListDepotFiles(fileSpecs []string) ([]DepotFile, error) {
 	if len(fileSpecs) == 0 {
 		fileSpecs = []string{"..."}
 	}
-	cmd := fmt.Sprintf(`%s fstat -T depotFile,headAction,headChange,headType,digest -Ol `+
-		`-F '^(headAction=move/delete | headAction=purge | headAction=archive | headAction=delete)'`, p.cmd())
+	cmd := fmt.Sprintf(`%s%s fstat -T depotFile,headAction,headChange,headType,digest -Ol `+
+		`-F '^(headAction=move/delete | headAction=purge | headAction=archive | headAction=delete)'`, p.cmd(), p.wireFormatFlag())
 	for _, fileSpec := range fileSpecs {
 		cmd += fmt.Sprintf(" //%s/%s", p.Client, fileSpec)
 	}
@@ -0,0 +1,150 @@
+package p4
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// File is the subset of *os.File that FS implementations hand back from CreateTemp.
+type File interface {
+	Name() string
+	Write(p []byte) (n int, err error)
+	Close() error
+}
+
+// FS abstracts the file IO that P4 needs to do on the local machine (as opposed to over the
+// wire to a Perforce server), so that it can be swapped for an in-memory implementation in
+// tests, or sandboxed to a particular directory when running inside a CI container where
+// $TMPDIR is restricted.
+type FS interface {
+	CreateTemp(dir, pattern string) (File, error)
+	Remove(name string) error
+	Rename(oldname, newname string) error
+	WriteFile(name string, data []byte) error
+	ReadFile(name string) ([]byte, error)
+}
+
+// osFS is the default, disk-backed FS, implemented directly on top of the os package.
+type osFS struct{}
+
+func (osFS) CreateTemp(dir, pattern string) (File, error) {
+	return os.CreateTemp(dir, pattern)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (osFS) WriteFile(name string, data []byte) error {
+	return os.WriteFile(name, data, 0644)
+}
+
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// WithFS overrides the filesystem p uses for local file IO (temp files and manifests).
+// It's primarily useful for tests, via NewMemFS, or for sandboxing p4harmonize to a directory
+// it's been given explicit access to.
+func (p *P4) WithFS(fs FS) *P4 {
+	p.fs = fs
+	return p
+}
+
+// memFile is the in-memory File returned by memFS.CreateTemp. Its contents are only committed
+// to the owning memFS on Close, matching how a real temp file's writes are visible once flushed.
+type memFile struct {
+	fs   *memFS
+	name string
+	buf  []byte
+}
+
+func (f *memFile) Name() string { return f.name }
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.buf = append(f.buf, p...)
+	return len(p), nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	f.fs.files[f.name] = append([]byte(nil), f.buf...)
+	return nil
+}
+
+// memFS is an in-memory FS, so that WriteTempFile and manifest code can be exercised
+// hermetically, without touching the real disk.
+type memFS struct {
+	mu      sync.Mutex
+	files   map[string][]byte
+	tempSeq int
+}
+
+// NewMemFS returns an empty in-memory FS, suitable for passing to P4.WithFS in tests.
+func NewMemFS() FS {
+	return &memFS{files: make(map[string][]byte)}
+}
+
+func (fs *memFS) CreateTemp(dir, pattern string) (File, error) {
+	fs.mu.Lock()
+	fs.tempSeq++
+	seq := fs.tempSeq
+	fs.mu.Unlock()
+
+	name := dir + "/" + strings.Replace(pattern, "*", fmt.Sprintf("%d", seq), 1)
+	if !strings.Contains(pattern, "*") {
+		name = dir + "/" + fmt.Sprintf("%s%d", pattern, seq)
+	}
+
+	f := &memFile{fs: fs, name: name}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (fs *memFS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, exists := fs.files[name]; !exists {
+		return fmt.Errorf("remove %s: file does not exist", name)
+	}
+	delete(fs.files, name)
+	return nil
+}
+
+func (fs *memFS) Rename(oldname, newname string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	data, exists := fs.files[oldname]
+	if !exists {
+		return fmt.Errorf("rename %s: file does not exist", oldname)
+	}
+	fs.files[newname] = data
+	delete(fs.files, oldname)
+	return nil
+}
+
+func (fs *memFS) WriteFile(name string, data []byte) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.files[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (fs *memFS) ReadFile(name string) ([]byte, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	data, exists := fs.files[name]
+	if !exists {
+		return nil, fmt.Errorf("open %s: file does not exist", name)
+	}
+	return append([]byte(nil), data...), nil
+}
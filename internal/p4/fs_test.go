@@ -0,0 +1,103 @@
+package p4
+
+import (
+	"testing"
+)
+
+func TestMemFSWriteReadFile(t *testing.T) {
+	fs := NewMemFS()
+
+	if err := fs.WriteFile("/tmp/foo", []byte("hello")); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	got, err := fs.ReadFile("/tmp/foo")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("ReadFile returned %q, want %q", got, "hello")
+	}
+}
+
+func TestMemFSReadFileMissing(t *testing.T) {
+	fs := NewMemFS()
+
+	if _, err := fs.ReadFile("/tmp/missing"); err == nil {
+		t.Fatal("expected error reading a file that was never written")
+	}
+}
+
+func TestMemFSCreateTempThenRename(t *testing.T) {
+	fs := NewMemFS()
+
+	f, err := fs.CreateTemp("/tmp", ".manifest-*.tmp")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	if _, err := f.Write([]byte("contents")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	tmpName := f.Name()
+	if err := fs.Rename(tmpName, "/tmp/final"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+
+	if _, err := fs.ReadFile(tmpName); err == nil {
+		t.Fatal("expected the temp name to no longer exist after Rename")
+	}
+
+	got, err := fs.ReadFile("/tmp/final")
+	if err != nil {
+		t.Fatalf("ReadFile(final): %v", err)
+	}
+	if string(got) != "contents" {
+		t.Fatalf("ReadFile(final) returned %q, want %q", got, "contents")
+	}
+}
+
+func TestMemFSCreateTempUniqueNames(t *testing.T) {
+	fs := NewMemFS()
+
+	a, err := fs.CreateTemp("/tmp", ".manifest-*.tmp")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	b, err := fs.CreateTemp("/tmp", ".manifest-*.tmp")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+
+	if a.Name() == b.Name() {
+		t.Fatalf("expected distinct temp names, got %q twice", a.Name())
+	}
+}
+
+func TestMemFSRemove(t *testing.T) {
+	fs := NewMemFS()
+
+	if err := fs.WriteFile("/tmp/foo", []byte("x")); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := fs.Remove("/tmp/foo"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := fs.ReadFile("/tmp/foo"); err == nil {
+		t.Fatal("expected the file to be gone after Remove")
+	}
+	if err := fs.Remove("/tmp/foo"); err == nil {
+		t.Fatal("expected Remove of an already-removed file to error")
+	}
+}
+
+func TestMemFSRenameMissing(t *testing.T) {
+	fs := NewMemFS()
+
+	if err := fs.Rename("/tmp/missing", "/tmp/dest"); err == nil {
+		t.Fatal("expected Rename of a nonexistent file to error")
+	}
+}
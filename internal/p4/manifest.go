@@ -0,0 +1,188 @@
+package p4
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ManifestMeta describes the context a manifest was captured in, so that a later run can tell
+// whether it's looking at the same source/destination pairing before trusting the recorded state.
+type ManifestMeta struct {
+	SourcePort   string
+	SourceStream string
+	DestPort     string
+	DestStream   string
+	StreamDepth  int
+	RunID        int
+}
+
+// WriteManifest persists files (the result of ListDepotFiles) to path in a recfile-style format:
+// a header record describing meta, a blank line, then one record per file. Writing is atomic --
+// the manifest is built in a temp file alongside path and renamed into place, so a crash or
+// interrupted run can never leave a half-written manifest behind.
+func (p *P4) WriteManifest(path string, files []DepotFile, meta ManifestMeta) error {
+	var sb strings.Builder
+	sb.Grow(128 + len(files)*128)
+
+	fmt.Fprintf(&sb, "SourcePort: %s\n", meta.SourcePort)
+	fmt.Fprintf(&sb, "SourceStream: %s\n", meta.SourceStream)
+	fmt.Fprintf(&sb, "DestPort: %s\n", meta.DestPort)
+	fmt.Fprintf(&sb, "DestStream: %s\n", meta.DestStream)
+	fmt.Fprintf(&sb, "StreamDepth: %d\n", meta.StreamDepth)
+	fmt.Fprintf(&sb, "RunID: %d\n", meta.RunID)
+
+	for _, f := range files {
+		sb.WriteString("\n")
+		fmt.Fprintf(&sb, "Path: %s\n", f.Path)
+		fmt.Fprintf(&sb, "HeadChange: %s\n", f.CL)
+		fmt.Fprintf(&sb, "HeadType: %s\n", f.Type)
+		fmt.Fprintf(&sb, "Digest: %s\n", f.Digest)
+		fmt.Fprintf(&sb, "HeadAction: %s\n", f.Action)
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := p.fs.CreateTemp(dir, ".manifest-*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating temp manifest in %s: %w", dir, err)
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write([]byte(sb.String())); err != nil {
+		tmp.Close()
+		p.fs.Remove(tmpName)
+		return fmt.Errorf("error writing temp manifest %s: %w", tmpName, err)
+	}
+	if err := tmp.Close(); err != nil {
+		p.fs.Remove(tmpName)
+		return fmt.Errorf("error closing temp manifest %s: %w", tmpName, err)
+	}
+
+	if err := p.fs.Rename(tmpName, path); err != nil {
+		p.fs.Remove(tmpName)
+		return fmt.Errorf("error renaming temp manifest %s to %s: %w", tmpName, path, err)
+	}
+
+	return nil
+}
+
+// ReadManifest reads a manifest written by WriteManifest, via p's FS, so that a manifest written
+// to an in-memory or sandboxed FS can be read back the same way. It tolerates a truncated or
+// garbled final record, since a manifest can be read right after a process was killed mid-write
+// (before the atomic rename in WriteManifest lands, the previous manifest is untouched, but a
+// manifest written by some other, less careful tool might not be) -- any record missing a Path is
+// dropped rather than treated as an error.
+func (p *P4) ReadManifest(path string) (ManifestMeta, []DepotFile, error) {
+	data, err := p.fs.ReadFile(path)
+	if err != nil {
+		return ManifestMeta{}, nil, fmt.Errorf("error opening manifest %s: %w", path, err)
+	}
+
+	var meta ManifestMeta
+	var out []DepotFile
+	var cur DepotFile
+	sawHeader := false
+
+	flush := func() {
+		if len(cur.Path) != 0 {
+			out = append(out, cur)
+		}
+		cur = DepotFile{}
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 {
+			if !sawHeader {
+				sawHeader = true
+			} else {
+				flush()
+			}
+			continue
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if !sawHeader {
+			switch key {
+			case "SourcePort":
+				meta.SourcePort = value
+			case "SourceStream":
+				meta.SourceStream = value
+			case "DestPort":
+				meta.DestPort = value
+			case "DestStream":
+				meta.DestStream = value
+			case "StreamDepth":
+				meta.StreamDepth, _ = strconv.Atoi(value)
+			case "RunID":
+				meta.RunID, _ = strconv.Atoi(value)
+			}
+			continue
+		}
+
+		switch key {
+		case "Path":
+			cur.Path = value
+		case "HeadChange":
+			cur.CL = value
+		case "HeadType":
+			cur.Type = value
+		case "Digest":
+			cur.Digest = value
+		case "HeadAction":
+			cur.Action = value
+		}
+	}
+	// the final record has no trailing blank line to flush it
+	flush()
+
+	if err := s.Err(); err != nil {
+		return ManifestMeta{}, nil, fmt.Errorf("error reading manifest %s: %w", path, err)
+	}
+
+	return meta, out, nil
+}
+
+// DiffManifests compares two sorted DepotFile slices (as returned by ListDepotFiles or
+// ReadManifest) and splits the difference into files that are new, files that are gone, and
+// files whose recorded CL, type, digest, or action changed. Harmonize drivers can use this to skip
+// fstat-and-diff work for any path that isn't in one of these three buckets.
+func DiffManifests(old, cur []DepotFile) (added, removed, changed []DepotFile) {
+	oldByPath := make(map[string]DepotFile, len(old))
+	for _, f := range old {
+		oldByPath[f.Path] = f
+	}
+	curByPath := make(map[string]DepotFile, len(cur))
+	for _, f := range cur {
+		curByPath[f.Path] = f
+	}
+
+	for _, f := range cur {
+		o, exists := oldByPath[f.Path]
+		if !exists {
+			added = append(added, f)
+			continue
+		}
+		if o.CL != f.CL || o.Type != f.Type || o.Digest != f.Digest || o.Action != f.Action {
+			changed = append(changed, f)
+		}
+	}
+
+	for _, f := range old {
+		if _, exists := curByPath[f.Path]; !exists {
+			removed = append(removed, f)
+		}
+	}
+
+	return added, removed, changed
+}
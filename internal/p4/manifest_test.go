@@ -0,0 +1,99 @@
+package p4
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWriteReadManifestRoundTrip(t *testing.T) {
+	p := New(nil, "", "", "", "").WithFS(NewMemFS())
+
+	meta := ManifestMeta{
+		SourcePort:   "ssl:source.example.com:1666",
+		SourceStream: "//Source/main",
+		DestPort:     "ssl:dest.example.com:1666",
+		DestStream:   "//Dest/main",
+		StreamDepth:  2,
+		RunID:        7,
+	}
+	files := []DepotFile{
+		{Path: "Engine/foo.cpp", Action: "edit", CL: "100", Type: "text", Digest: "ABC123"},
+		{Path: "Engine/bar.bin", Action: "add", CL: "101", Type: "binary+x", Digest: "DEF456"},
+	}
+
+	const path = "/manifests/state.manifest"
+	if err := p.WriteManifest(path, files, meta); err != nil {
+		t.Fatalf("WriteManifest: %v", err)
+	}
+
+	gotMeta, gotFiles, err := p.ReadManifest(path)
+	if err != nil {
+		t.Fatalf("ReadManifest: %v", err)
+	}
+
+	if gotMeta != meta {
+		t.Fatalf("ReadManifest meta = %+v, want %+v", gotMeta, meta)
+	}
+	if !reflect.DeepEqual(gotFiles, files) {
+		t.Fatalf("ReadManifest files = %+v, want %+v", gotFiles, files)
+	}
+}
+
+func TestReadManifestTruncatedFinalRecord(t *testing.T) {
+	fs := NewMemFS()
+	p := New(nil, "", "", "", "").WithFS(fs)
+
+	const path = "/manifests/state.manifest"
+	// a header, one complete record, then a final record missing its Path field
+	garbled := "SourcePort: p4.example.com:1666\n" +
+		"SourceStream: //Source/main\n" +
+		"DestPort: p4.example.com:1667\n" +
+		"DestStream: //Dest/main\n" +
+		"StreamDepth: 2\n" +
+		"RunID: 1\n" +
+		"\n" +
+		"Path: Engine/foo.cpp\n" +
+		"HeadChange: 100\n" +
+		"HeadType: text\n" +
+		"Digest: ABC123\n" +
+		"HeadAction: edit\n" +
+		"\n" +
+		"HeadChange: 101\n" +
+		"HeadType: text\n"
+	if err := fs.WriteFile(path, []byte(garbled)); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	_, files, err := p.ReadManifest(path)
+	if err != nil {
+		t.Fatalf("ReadManifest: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "Engine/foo.cpp" {
+		t.Fatalf("ReadManifest files = %+v, want only the complete Engine/foo.cpp record", files)
+	}
+}
+
+func TestDiffManifests(t *testing.T) {
+	old := []DepotFile{
+		{Path: "a", CL: "1", Digest: "A"},
+		{Path: "b", CL: "1", Digest: "B"},
+		{Path: "c", CL: "1", Digest: "C"},
+	}
+	cur := []DepotFile{
+		{Path: "a", CL: "1", Digest: "A"},   // unchanged
+		{Path: "b", CL: "2", Digest: "BB"},  // changed
+		{Path: "d", CL: "1", Digest: "D"},   // added
+	}
+
+	added, removed, changed := DiffManifests(old, cur)
+
+	if len(added) != 1 || added[0].Path != "d" {
+		t.Fatalf("added = %+v, want just d", added)
+	}
+	if len(removed) != 1 || removed[0].Path != "c" {
+		t.Fatalf("removed = %+v, want just c", removed)
+	}
+	if len(changed) != 1 || changed[0].Path != "b" {
+		t.Fatalf("changed = %+v, want just b", changed)
+	}
+}
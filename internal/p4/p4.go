@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
 	"sort"
 	"strconv"
 	"strings"
@@ -20,19 +19,23 @@ type P4 struct {
 	Charset string
 	Client  string
 
-	sh *bsh.Bsh
+	sh         *bsh.Bsh
+	fs         FS
+	wireFormat WireFormat
 
 	streamMutex      sync.Mutex
-	streamDepthCache int    // read/write requires mutex lock
+	streamDepthCache int // read/write requires mutex lock
 }
 
 func New(sh *bsh.Bsh, port, user, charset, client string) *P4 {
 	return &P4{
-		Port:    port,
-		User:    user,
-		Charset: charset,
-		Client:  client,
-		sh:      sh,
+		Port:       port,
+		User:       user,
+		Charset:    charset,
+		Client:     client,
+		sh:         sh,
+		fs:         osFS{},
+		wireFormat: WireFormatText,
 	}
 }
 
@@ -189,9 +192,22 @@ func (x DepotFileCaseInsensitive) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
 
 // runAndParseDepotFiles calls the given command, which is expected to return a list of records, each
 // with at least a depotFile, and optionally also a type, change, action, digest, headType, headChange,
-// and headAction.
+// and headAction. The command is parsed according to p.wireFormat.
 // The results are then sorted by Path (case-insensitive) and returned.
 func (p *P4) runAndParseDepotFiles(cmd string) ([]DepotFile, error) {
+	switch p.wireFormat {
+	case WireFormatPyMarshal:
+		return p.runAndParseDepotFilesStructured(cmd, decodeMarshalDict)
+	case WireFormatJSON:
+		return p.runAndParseDepotFilesStructured(cmd, decodeJSONDict)
+	default:
+		return p.runAndParseDepotFilesText(cmd)
+	}
+}
+
+// runAndParseDepotFilesText parses the classic line-oriented "... <tag> <value>" output of
+// "p4 -ztag" commands.
+func (p *P4) runAndParseDepotFilesText(cmd string) ([]DepotFile, error) {
 	if !strings.Contains(cmd, "-ztag") && !strings.Contains(cmd, "-z tag") && !strings.Contains(cmd, "fstat") {
 		return nil, fmt.Errorf("missing '-z tag' in non-fstat cmd: %s", cmd)
 	}
@@ -361,22 +377,23 @@ func UnescapePath(path string) (string, error) {
 	return sb.String(), nil
 }
 
-// WriteTempFile creates a temporary file then writes the passed contents to that file.
+// WriteTempFile creates a temporary file then writes the passed contents to that file, going
+// through p's FS so that callers can sandbox or fake the filesystem p4harmonize touches.
 // To understand "filepattern", see the os.CreateTemp() documentation for the "pattern" argument.
 // If there is no error in creating the file, then the returned func must be called
 // when it is safe to delete the created temporary file.
-func WriteTempFile(filepattern, contents string) (fnCleanup func(), filename string, err error) {
-	file, err := os.CreateTemp("", filepattern)
+func (p *P4) WriteTempFile(filepattern, contents string) (fnCleanup func(), filename string, err error) {
+	file, err := p.fs.CreateTemp("", filepattern)
 	if err != nil {
 		return nil, "", fmt.Errorf("error creating temp file for pattern %s: %w", filepattern, err)
 	}
 	defer file.Close()
 
-	_, err = file.WriteString(contents)
+	_, err = file.Write([]byte(contents))
 	if err != nil {
 		return nil, "", fmt.Errorf("error writing temp file for pattern %s: %w", filepattern, err)
 	}
 
 	name := file.Name()
-	return func() { os.Remove(name) }, name, nil
+	return func() { p.fs.Remove(name) }, name, nil
 }
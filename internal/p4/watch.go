@@ -0,0 +1,209 @@
+package p4
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Delta describes the files that changed between two points a Watcher has seen, in the same
+// shape DiffManifests produces.
+type Delta struct {
+	Added   []DepotFile
+	Removed []DepotFile
+	Changed []DepotFile
+}
+
+// IsEmpty reports whether the delta has nothing in it.
+func (d Delta) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// WatchConfig controls how Watch polls for upstream changes.
+type WatchConfig struct {
+	// Interval is how often to poll the source depot for new submitted changelists.
+	Interval time.Duration
+	// Jitter, if non-zero, is added on top of Interval (chosen uniformly between 0 and Jitter)
+	// so that multiple watchers don't all hammer the server in lockstep.
+	Jitter time.Duration
+	// MaxBackoff caps the extra delay Watch adds after a failed poll, which doubles with each
+	// consecutive failure; the regular Interval (plus jitter) is still waited on top of it.
+	MaxBackoff time.Duration
+	// Paths are the fileSpecs (passed to ListDepotFiles) that make up the watched subtree.
+	Paths []string
+	// IgnoreUsers lists Perforce usernames whose submits should not trigger onChange, so a
+	// harmonize bot doesn't react to the changes it submitted itself.
+	IgnoreUsers []string
+}
+
+// Watch polls the source depot at cfg.Interval (plus jitter) for newly submitted changelists. When
+// the head changelist advances past the last one Watch has seen, it computes the delta against the
+// previously observed file list and invokes onChange. Submits authored by a user in
+// cfg.IgnoreUsers are skipped, so a harmonize bot doesn't re-trigger itself on its own commits.
+// Rapid bursts of submits are debounced: once a change is seen, Watch keeps polling until a full
+// Interval passes with no further change before calling onChange, so a flurry of back-to-back
+// submits is delivered as a single delta. Watch checks ctx between polls and returns ctx.Err() once
+// it's done, or, if onChange or listing the settled file list fails first, an error wrapping that
+// failure. Note that ctx is not wired into the
+// underlying "p4" subprocesses (bsh.Bsh runs them with plain exec.Command, with no support for
+// exec.CommandContext), so canceling ctx while a poll's "p4" command is already running won't kill
+// that command -- Watch will only stop once the in-flight call returns.
+func (p *P4) Watch(ctx context.Context, cfg WatchConfig, onChange func(delta Delta) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	lastFiles, err := p.ListDepotFiles(cfg.Paths)
+	if err != nil {
+		return fmt.Errorf("error seeding initial file list: %w", err)
+	}
+
+	lastCL, _, err := p.headSubmittedChange(cfg.Paths)
+	if err != nil {
+		return fmt.Errorf("error seeding initial changelist: %w", err)
+	}
+
+	backoff := cfg.Interval
+	var pendingCL int
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(jitter(cfg.Interval, cfg.Jitter)):
+		}
+
+		cl, user, err := p.headSubmittedChange(cfg.Paths)
+		if err != nil {
+			backoff = nextBackoff(backoff, cfg.MaxBackoff)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		backoff = cfg.Interval
+
+		if cl <= lastCL {
+			// nothing new since the last delta we delivered
+			pendingCL = 0
+			continue
+		}
+		if cl != pendingCL {
+			// changelist just advanced (or advanced again); wait one more interval to see
+			// whether it's still moving before treating it as settled
+			pendingCL = cl
+			continue
+		}
+
+		// cl has held steady for a full Interval -- treat the burst as over
+		pendingCL = 0
+
+		newFiles, err := p.ListDepotFiles(cfg.Paths)
+		if err != nil {
+			return fmt.Errorf("error listing files at CL %d: %w", cl, err)
+		}
+
+		if isIgnoredUser(user, cfg.IgnoreUsers) {
+			// still adopt the post-commit file list, so the ignored commit's files aren't
+			// reported as part of the next (non-ignored) delta
+			lastFiles = newFiles
+			lastCL = cl
+			continue
+		}
+
+		added, removed, changed := DiffManifests(lastFiles, newFiles)
+		delta := Delta{Added: added, Removed: removed, Changed: changed}
+		lastFiles = newFiles
+		lastCL = cl
+
+		if delta.IsEmpty() {
+			continue
+		}
+
+		if err := onChange(delta); err != nil {
+			return fmt.Errorf("error handling delta at CL %d: %w", cl, err)
+		}
+	}
+}
+
+// headSubmittedChange returns the changelist number and authoring user of the most recent
+// submitted change across paths.
+func (p *P4) headSubmittedChange(paths []string) (cl int, user string, err error) {
+	if len(paths) == 0 {
+		paths = []string{"..."}
+	}
+
+	cmd := fmt.Sprintf("%s changes -m1 -s submitted", p.cmd())
+	for _, path := range paths {
+		cmd += fmt.Sprintf(" //%s/%s", p.Client, path)
+	}
+
+	err = p.cmdAndScan(cmd, func(rawLine string) error {
+		line := strings.TrimSpace(rawLine)
+		if len(line) == 0 {
+			return nil
+		}
+		parsedCL, parsedUser, parseErr := parseChangesSummary(line)
+		if parseErr != nil {
+			return parseErr
+		}
+		if parsedCL > cl {
+			cl = parsedCL
+			user = parsedUser
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, "", fmt.Errorf("error getting head submitted change: %w", err)
+	}
+
+	return cl, user, nil
+}
+
+// parseChangesSummary parses a single line of "p4 changes" output, eg:
+// "Change 12345 on 2024/01/02 by alice@workspace 'Fix thing'"
+func parseChangesSummary(line string) (cl int, user string, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 6 || fields[0] != "Change" || fields[4] != "by" {
+		return 0, "", fmt.Errorf("unexpected 'p4 changes' output: %s", line)
+	}
+
+	cl, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, "", fmt.Errorf("error parsing changelist number in %q: %w", line, err)
+	}
+
+	userAtClient := fields[5]
+	user, _, _ = strings.Cut(userAtClient, "@")
+
+	return cl, user, nil
+}
+
+func isIgnoredUser(user string, ignoreUsers []string) bool {
+	for _, ignored := range ignoreUsers {
+		if user == ignored {
+			return true
+		}
+	}
+	return false
+}
+
+func jitter(interval, maxJitter time.Duration) time.Duration {
+	if maxJitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Int63n(int64(maxJitter)))
+}
+
+func nextBackoff(current, max time.Duration) time.Duration {
+	next := current * 2
+	if max > 0 && next > max {
+		return max
+	}
+	return next
+}
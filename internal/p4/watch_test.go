@@ -0,0 +1,237 @@
+package p4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danbrakeley/bsh"
+)
+
+func TestParseChangesSummary(t *testing.T) {
+	cases := []struct {
+		line     string
+		wantCL   int
+		wantUser string
+		wantErr  bool
+	}{
+		{"Change 12345 on 2024/01/02 by alice@workspace 'Fix thing'", 12345, "alice", false},
+		{"Change 1 on 2024/01/02 by bot@ci-client 'automated'", 1, "bot", false},
+		{"not a changes line at all", 0, "", true},
+		{"Change abc on 2024/01/02 by alice@workspace 'Fix thing'", 0, "", true},
+	}
+
+	for _, c := range cases {
+		cl, user, err := parseChangesSummary(c.line)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseChangesSummary(%q): expected error, got none", c.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseChangesSummary(%q): unexpected error: %v", c.line, err)
+			continue
+		}
+		if cl != c.wantCL || user != c.wantUser {
+			t.Errorf("parseChangesSummary(%q) = (%d, %q), want (%d, %q)", c.line, cl, user, c.wantCL, c.wantUser)
+		}
+	}
+}
+
+func TestIsIgnoredUser(t *testing.T) {
+	ignore := []string{"bot", "harmonize-svc"}
+	if !isIgnoredUser("bot", ignore) {
+		t.Error("expected bot to be ignored")
+	}
+	if isIgnoredUser("alice", ignore) {
+		t.Error("expected alice not to be ignored")
+	}
+	if isIgnoredUser("alice", nil) {
+		t.Error("expected no one to be ignored when IgnoreUsers is empty")
+	}
+}
+
+func TestJitter(t *testing.T) {
+	if got := jitter(10*time.Second, 0); got != 10*time.Second {
+		t.Errorf("jitter with no max = %v, want 10s", got)
+	}
+	for i := 0; i < 100; i++ {
+		got := jitter(10*time.Second, 5*time.Second)
+		if got < 10*time.Second || got >= 15*time.Second {
+			t.Fatalf("jitter(10s, 5s) = %v, want in [10s, 15s)", got)
+		}
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	if got := nextBackoff(time.Second, 10*time.Second); got != 2*time.Second {
+		t.Errorf("nextBackoff(1s, 10s) = %v, want 2s", got)
+	}
+	if got := nextBackoff(8*time.Second, 10*time.Second); got != 10*time.Second {
+		t.Errorf("nextBackoff(8s, 10s) = %v, want capped at 10s", got)
+	}
+	if got := nextBackoff(time.Second, 0); got != 2*time.Second {
+		t.Errorf("nextBackoff(1s, 0) = %v, want 2s (no cap)", got)
+	}
+}
+
+// fakeP4State is the mutable, file-backed state read by the fake "p4" executable installed on
+// $PATH by newFakeP4. Tests mutate it with set() to simulate new submits landing upstream.
+type fakeP4State struct {
+	dir string
+}
+
+func (s fakeP4State) set(changes, fstat string) error {
+	for name, contents := range map[string]string{"changes.txt": changes, "fstat.txt": fstat} {
+		tmp := filepath.Join(s.dir, name+".tmp")
+		if err := os.WriteFile(tmp, []byte(contents), 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newFakeP4 installs a shell script named "p4" at the front of $PATH that answers "p4 changes"
+// and "p4 fstat" invocations by catting the corresponding state file, so Watch can be driven
+// without a live Perforce server. It returns the state the test can mutate to simulate submits.
+func newFakeP4(t *testing.T) fakeP4State {
+	t.Helper()
+
+	dir := t.TempDir()
+	script := "#!/bin/sh\n" +
+		"case \"$*\" in\n" +
+		"  *fstat*) cat \"" + dir + "/fstat.txt\" ;;\n" +
+		"  *changes*) cat \"" + dir + "/changes.txt\" ;;\n" +
+		"esac\n"
+	bin := filepath.Join(dir, "p4")
+	if err := os.WriteFile(bin, []byte(script), 0755); err != nil {
+		t.Fatalf("writing fake p4 script: %v", err)
+	}
+
+	origPath := os.Getenv("PATH")
+	if err := os.Setenv("PATH", dir+string(os.PathListSeparator)+origPath); err != nil {
+		t.Fatalf("setting PATH: %v", err)
+	}
+	t.Cleanup(func() { os.Setenv("PATH", origPath) })
+
+	state := fakeP4State{dir: dir}
+	if err := state.set("", ""); err != nil {
+		t.Fatalf("seeding fake p4 state: %v", err)
+	}
+	return state
+}
+
+func changesLine(cl int, user string) string {
+	return fmt.Sprintf("Change %d on 2024/01/02 by %s@client 'test'\n", cl, user)
+}
+
+func fstatRecord(path, action, cl, digest string) string {
+	return fmt.Sprintf(
+		"... depotFile //depot/%s\n"+
+			"... headAction %s\n"+
+			"... headChange %s\n"+
+			"... headType text\n"+
+			"... digest %s\n\n",
+		path, action, cl, digest)
+}
+
+func TestWatchDebounceAndIgnoreUser(t *testing.T) {
+	state := newFakeP4(t)
+
+	fileA := fstatRecord("Engine/a.cpp", "add", "100", "AAA")
+	if err := state.set(changesLine(100, "seed"), fileA); err != nil {
+		t.Fatalf("seeding state: %v", err)
+	}
+
+	p := New(&bsh.Bsh{}, "", "", "", "")
+	p.streamDepthCache = 1 // skip Depot()/GetClientSpec(), which this trimmed tree can't exercise
+
+	cfg := WatchConfig{
+		Interval:    10 * time.Millisecond,
+		MaxBackoff:  50 * time.Millisecond,
+		IgnoreUsers: []string{"bot"},
+	}
+
+	var mu sync.Mutex
+	var deltas []Delta
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	chDone := make(chan error, 1)
+	go func() {
+		chDone <- p.Watch(ctx, cfg, func(delta Delta) error {
+			mu.Lock()
+			deltas = append(deltas, delta)
+			mu.Unlock()
+			return nil
+		})
+	}()
+
+	snapshot := func() []Delta {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]Delta(nil), deltas...)
+	}
+
+	// waitForDeltaCount polls until onChange has fired n times, or fails the test once it's
+	// clear that's not going to happen within the debounce window.
+	waitForDeltaCount := func(n int) []Delta {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if got := snapshot(); len(got) >= n {
+				return got
+			}
+			time.Sleep(cfg.Interval)
+		}
+		t.Fatalf("timed out waiting for %d onChange call(s), got %d", n, len(snapshot()))
+		return nil
+	}
+
+	// bot submits CL 101, adding Engine/b.cpp: should update internal state but not fire onChange.
+	fileB := fstatRecord("Engine/b.cpp", "add", "101", "BBB")
+	if err := state.set(changesLine(101, "bot"), fileA+fileB); err != nil {
+		t.Fatalf("updating state: %v", err)
+	}
+	// give the debounce window a chance to settle on CL 101 before asserting it stayed quiet
+	time.Sleep(10 * cfg.Interval)
+	if gotAfterBot := len(snapshot()); gotAfterBot != 0 {
+		t.Fatalf("onChange fired %d times for an ignored user's submit, want 0", gotAfterBot)
+	}
+
+	// alice submits CL 102, adding Engine/c.cpp: should fire onChange with only c.cpp as added,
+	// proving the bot's b.cpp (added while ignored) was folded into the baseline rather than
+	// showing up again here.
+	fileC := fstatRecord("Engine/c.cpp", "add", "102", "CCC")
+	if err := state.set(changesLine(102, "alice"), fileA+fileB+fileC); err != nil {
+		t.Fatalf("updating state: %v", err)
+	}
+	gotDeltas := waitForDeltaCount(1)
+
+	if len(gotDeltas) != 1 {
+		t.Fatalf("onChange fired %d times for alice's submit, want 1 (deltas: %+v)", len(gotDeltas), gotDeltas)
+	}
+	added := gotDeltas[0].Added
+	if len(added) != 1 || added[0].Path != "Engine/c.cpp" {
+		t.Fatalf("delta.Added = %+v, want just Engine/c.cpp (Engine/b.cpp should already be in the baseline)", added)
+	}
+
+	cancel()
+	select {
+	case err := <-chDone:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Watch returned %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Watch did not return after ctx was canceled")
+	}
+}
@@ -0,0 +1,304 @@
+package p4
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WireFormat selects how p.runAndParseDepotFiles talks to the "p4" client: the classic
+// line-oriented tag output, or one of the structured, self-delimiting formats that avoid the
+// line scanner's trouble with filenames containing newlines or embedded spaces.
+type WireFormat int
+
+const (
+	// WireFormatText is the original "p4 -ztag" line-oriented output.
+	WireFormatText WireFormat = iota
+	// WireFormatPyMarshal uses "p4 -G", which emits a stream of Python-marshalled dicts.
+	WireFormatPyMarshal
+	// WireFormatJSON uses "p4 -Mj -ztag", which emits one JSON object per record.
+	WireFormatJSON
+)
+
+// SetWireFormat changes how p talks to "p4" for commands that go through runAndParseDepotFiles.
+func (p *P4) SetWireFormat(wf WireFormat) {
+	p.wireFormat = wf
+}
+
+// wireFormatFlag returns the global "p4" flags needed to produce p.wireFormat's output, to be
+// inserted into a command right after p.cmd().
+func (p *P4) wireFormatFlag() string {
+	switch p.wireFormat {
+	case WireFormatPyMarshal:
+		return " -G"
+	case WireFormatJSON:
+		return " -Mj -ztag"
+	default:
+		return ""
+	}
+}
+
+// DetectWireFormat picks the fastest WireFormat the connected server supports, by checking the
+// "Server version" line reported by "p4 info": JSON output (-Mj) is only reliable on servers from
+// 2021.1 onward, but -G (Python marshal) has been supported by every server p4harmonize cares
+// about, so it's the fallback before giving up and using the original text format.
+func (p *P4) DetectWireFormat() (WireFormat, error) {
+	var sb strings.Builder
+	if err := p.sh.Cmdf(`%s info`, p.cmd()).Out(&sb).RunErr(); err != nil {
+		return WireFormatText, fmt.Errorf("error running p4 info: %w", err)
+	}
+
+	for _, line := range strings.Split(sb.String(), "\n") {
+		line = strings.TrimSpace(line)
+		rest, found := strings.CutPrefix(line, "Server version:")
+		if !found {
+			continue
+		}
+
+		year := serverReleaseYear(rest)
+		if year >= 2021 {
+			return WireFormatJSON, nil
+		}
+		return WireFormatPyMarshal, nil
+	}
+
+	return WireFormatPyMarshal, nil
+}
+
+// serverReleaseYear picks the 4-digit release year out of a "Server version:" value, eg
+// "P4D/LINUX26X86_64/2023.1/2513900 (2023/05/10)" -> 2023. Returns 0 if none is found.
+func serverReleaseYear(version string) int {
+	for _, field := range strings.FieldsFunc(version, func(r rune) bool { return r == '/' || r == ' ' }) {
+		if len(field) < 4 {
+			continue
+		}
+		year, err := strconv.Atoi(field[:4])
+		if err == nil && year > 1990 && year < 3000 {
+			return year
+		}
+	}
+	return 0
+}
+
+// depotFileRecord is the record shape shared by the structured decoders: a flat map of field
+// name to string value, matching the tag names used by "p4 fstat".
+type depotFileRecord map[string]string
+
+// runAndParseDepotFilesStructured runs cmd and decodes its output using decode, one record at a
+// time, converting each into a DepotFile the same way the text parser does.
+func (p *P4) runAndParseDepotFilesStructured(cmd string, decode func(r *bufio.Reader) (depotFileRecord, error)) ([]DepotFile, error) {
+	streamDepth, err := p.StreamDepth()
+	if err != nil {
+		return nil, err
+	}
+
+	r, w := io.Pipe()
+	chCmd := make(chan error, 1)
+	go func() {
+		err := p.sh.Cmd(cmd).Out(w).RunErr()
+		w.Close()
+		chCmd <- err
+	}()
+
+	out := make([]DepotFile, 0, 1024*1024)
+	var prefix string
+	var decodeErr error
+	br := bufio.NewReader(r)
+
+	for {
+		rec, err := decode(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			decodeErr = fmt.Errorf("error decoding record: %w", err)
+			r.CloseWithError(decodeErr)
+			break
+		}
+
+		df, newPrefix, err := depotFileFromRecord(rec, streamDepth, prefix)
+		if err != nil {
+			decodeErr = err
+			r.CloseWithError(decodeErr)
+			break
+		}
+		prefix = newPrefix
+		if len(df.Path) != 0 {
+			out = append(out, df)
+		}
+	}
+
+	// wait for the command to finish regardless of how the decode loop above ended, so the
+	// "p4" subprocess is never left running after this function returns
+	cmdErr := <-chCmd
+	if decodeErr != nil {
+		return nil, fmt.Errorf("error listing files: %w", decodeErr)
+	}
+	if cmdErr != nil {
+		return nil, fmt.Errorf("error listing files: %w", cmdErr)
+	}
+
+	sort.Sort(DepotFileCaseInsensitive(out))
+	return out, nil
+}
+
+// depotFileFromRecord builds a DepotFile out of a decoded record, stripping the depot prefix the
+// same way the text parser does (falls back from the "head*" fields to their non-head
+// equivalents, since some commands -- like "p4 files" -- only report the latter).
+func depotFileFromRecord(rec depotFileRecord, streamDepth int, prefix string) (DepotFile, string, error) {
+	var df DepotFile
+
+	raw, exists := rec["depotFile"]
+	if !exists {
+		return DepotFile{}, prefix, nil
+	}
+
+	if len(prefix) == 0 {
+		var err error
+		prefix, err = getDepotPrefix(raw, streamDepth)
+		if err != nil {
+			return DepotFile{}, prefix, fmt.Errorf("error parsing depot prefix: %w", err)
+		}
+	}
+	df.Path = raw[len(prefix):]
+
+	if v, ok := rec["headAction"]; ok {
+		df.Action = v
+	} else if v, ok := rec["action"]; ok {
+		df.Action = v
+	}
+
+	if v, ok := rec["headChange"]; ok {
+		df.CL = v
+	} else if v, ok := rec["change"]; ok {
+		df.CL = v
+	}
+
+	if v, ok := rec["headType"]; ok {
+		df.Type = v
+	} else if v, ok := rec["type"]; ok {
+		df.Type = v
+	}
+
+	df.Digest = rec["digest"]
+
+	return df, prefix, nil
+}
+
+// decodeJSONDict decodes a single newline-delimited JSON object, as emitted by "p4 -Mj -ztag".
+func decodeJSONDict(r *bufio.Reader) (depotFileRecord, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return nil, err
+	}
+	line = strings.TrimSpace(line)
+	if len(line) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		return decodeJSONDict(r)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &raw); err != nil {
+		return nil, fmt.Errorf("error decoding json record %q: %w", line, err)
+	}
+
+	rec := make(depotFileRecord, len(raw))
+	for k, v := range raw {
+		rec[k] = fmt.Sprintf("%v", v)
+	}
+	return rec, nil
+}
+
+// decodeMarshalDict decodes a single Python-marshalled dict, as emitted by "p4 -G", which is a
+// stream of back-to-back dicts with no other framing. Each dict is '{' followed by marshalled
+// key/value pairs and terminated by a lone TYPE_NULL ('0') byte. Only the scalar types p4 -G
+// actually emits for fstat records are supported: strings, ints, booleans, and None.
+func decodeMarshalDict(r *bufio.Reader) (depotFileRecord, error) {
+	marker, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if marker != '{' {
+		return nil, fmt.Errorf("expected marshal dict marker '{', got %q", marker)
+	}
+
+	rec := make(depotFileRecord)
+	for {
+		keyType, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("error reading marshal dict key: %w", err)
+		}
+		if keyType == '0' {
+			return rec, nil
+		}
+		if keyType != 's' {
+			return nil, fmt.Errorf("unsupported marshal dict key type %q", keyType)
+		}
+		key, err := readMarshalString(r)
+		if err != nil {
+			return nil, fmt.Errorf("error reading marshal dict key: %w", err)
+		}
+
+		value, err := readMarshalScalar(r)
+		if err != nil {
+			return nil, fmt.Errorf("error reading marshal value for %q: %w", key, err)
+		}
+		rec[key] = value
+	}
+}
+
+// readMarshalScalar reads one marshalled value and renders it as a string.
+func readMarshalScalar(r *bufio.Reader) (string, error) {
+	valueType, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+
+	switch valueType {
+	case 's':
+		return readMarshalString(r)
+	case 'i':
+		return readMarshalInt(r)
+	case 'T':
+		return "true", nil
+	case 'F':
+		return "false", nil
+	case 'N':
+		return "", nil
+	default:
+		return "", fmt.Errorf("unsupported marshal value type %q", valueType)
+	}
+}
+
+func readMarshalString(r *bufio.Reader) (string, error) {
+	lenBuf := make([]byte, 4)
+	if _, err := io.ReadFull(r, lenBuf); err != nil {
+		return "", fmt.Errorf("error reading marshal string length: %w", err)
+	}
+	n := int32(binary.LittleEndian.Uint32(lenBuf))
+	if n < 0 {
+		return "", fmt.Errorf("negative marshal string length: %d", n)
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", fmt.Errorf("error reading marshal string of length %d: %w", n, err)
+	}
+	return string(buf), nil
+}
+
+func readMarshalInt(r *bufio.Reader) (string, error) {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", fmt.Errorf("error reading marshal int: %w", err)
+	}
+	v := int32(binary.LittleEndian.Uint32(buf))
+	return strconv.Itoa(int(v)), nil
+}
@@ -0,0 +1,300 @@
+package p4
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func encodeMarshalString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('s')
+	var lenBuf [4]byte
+	binary.LittleEndian.PutUint32(lenBuf[:], uint32(len(s)))
+	buf.Write(lenBuf[:])
+	buf.WriteString(s)
+}
+
+func encodeMarshalDict(buf *bytes.Buffer, fields map[string]string) {
+	buf.WriteByte('{')
+	for k, v := range fields {
+		encodeMarshalString(buf, k)
+		encodeMarshalString(buf, v)
+	}
+	buf.WriteByte('0')
+}
+
+func TestDecodeMarshalDict(t *testing.T) {
+	var buf bytes.Buffer
+	encodeMarshalDict(&buf, map[string]string{
+		"depotFile":  "//depot/Engine/foo.cpp",
+		"headAction": "edit",
+		"headChange": "100",
+		"headType":   "text",
+		"digest":     "ABC123",
+	})
+
+	r := bufio.NewReader(&buf)
+	rec, err := decodeMarshalDict(r)
+	if err != nil {
+		t.Fatalf("decodeMarshalDict: %v", err)
+	}
+	if rec["depotFile"] != "//depot/Engine/foo.cpp" || rec["headChange"] != "100" {
+		t.Fatalf("decodeMarshalDict returned %+v", rec)
+	}
+
+	if _, err := decodeMarshalDict(r); err != io.EOF {
+		t.Fatalf("expected io.EOF at end of stream, got %v", err)
+	}
+}
+
+func TestDecodeMarshalDictMultipleRecords(t *testing.T) {
+	var buf bytes.Buffer
+	encodeMarshalDict(&buf, map[string]string{"depotFile": "//depot/a"})
+	encodeMarshalDict(&buf, map[string]string{"depotFile": "//depot/b"})
+
+	r := bufio.NewReader(&buf)
+	first, err := decodeMarshalDict(r)
+	if err != nil {
+		t.Fatalf("decodeMarshalDict (1st): %v", err)
+	}
+	second, err := decodeMarshalDict(r)
+	if err != nil {
+		t.Fatalf("decodeMarshalDict (2nd): %v", err)
+	}
+	if first["depotFile"] != "//depot/a" || second["depotFile"] != "//depot/b" {
+		t.Fatalf("got records %+v, %+v", first, second)
+	}
+}
+
+func TestDecodeMarshalDictBadMarker(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("not a dict"))
+	if _, err := decodeMarshalDict(r); err == nil {
+		t.Fatal("expected an error for input not starting with a dict marker")
+	}
+}
+
+func TestDecodeJSONDict(t *testing.T) {
+	input := `{"depotFile":"//depot/Engine/foo.cpp","headAction":"edit","headChange":100,"headType":"text","digest":"ABC123"}` + "\n" +
+		`{"depotFile":"//depot/Engine/bar.cpp","headAction":"add","headChange":101,"headType":"binary","digest":"DEF456"}` + "\n"
+
+	r := bufio.NewReader(strings.NewReader(input))
+
+	first, err := decodeJSONDict(r)
+	if err != nil {
+		t.Fatalf("decodeJSONDict (1st): %v", err)
+	}
+	if first["depotFile"] != "//depot/Engine/foo.cpp" || first["headChange"] != "100" {
+		t.Fatalf("decodeJSONDict (1st) = %+v", first)
+	}
+
+	second, err := decodeJSONDict(r)
+	if err != nil {
+		t.Fatalf("decodeJSONDict (2nd): %v", err)
+	}
+	if second["depotFile"] != "//depot/Engine/bar.cpp" {
+		t.Fatalf("decodeJSONDict (2nd) = %+v", second)
+	}
+
+	if _, err := decodeJSONDict(r); err != io.EOF {
+		t.Fatalf("expected io.EOF at end of stream, got %v", err)
+	}
+}
+
+func TestDepotFileFromRecord(t *testing.T) {
+	rec := depotFileRecord{
+		"depotFile":  "//depot/main/Engine/foo.cpp",
+		"headAction": "edit",
+		"headChange": "100",
+		"headType":   "text",
+		"digest":     "ABC123",
+	}
+
+	df, prefix, err := depotFileFromRecord(rec, 1, "")
+	if err != nil {
+		t.Fatalf("depotFileFromRecord: %v", err)
+	}
+	if prefix != "//depot/" {
+		t.Fatalf("prefix = %q, want //depot/", prefix)
+	}
+	if df.Path != "main/Engine/foo.cpp" || df.Action != "edit" || df.CL != "100" || df.Type != "text" || df.Digest != "ABC123" {
+		t.Fatalf("depotFileFromRecord = %+v", df)
+	}
+}
+
+func TestDepotFileFromRecordFallsBackToNonHeadFields(t *testing.T) {
+	rec := depotFileRecord{
+		"depotFile": "//depot/main/Engine/foo.cpp",
+		"action":    "edit",
+		"change":    "100",
+		"type":      "text",
+	}
+
+	df, _, err := depotFileFromRecord(rec, 1, "//depot/")
+	if err != nil {
+		t.Fatalf("depotFileFromRecord: %v", err)
+	}
+	if df.Action != "edit" || df.CL != "100" || df.Type != "text" {
+		t.Fatalf("depotFileFromRecord = %+v", df)
+	}
+}
+
+func TestServerReleaseYear(t *testing.T) {
+	cases := map[string]int{
+		"P4D/LINUX26X86_64/2023.1/2513900 (2023/05/10)": 2023,
+		"P4D/LINUX26X86_64/2019.1/1876790 (2019/06/11)": 2019,
+		"garbage with no year":                          0,
+	}
+	for version, want := range cases {
+		if got := serverReleaseYear(version); got != want {
+			t.Errorf("serverReleaseYear(%q) = %d, want %d", version, got, want)
+		}
+	}
+}
+
+// --- benchmark: compare the cost of decoding a synthetic fstat-shaped corpus under each
+// wire format. The text-format decode replicates runAndParseDepotFilesText's per-line parsing
+// (it can't call that method directly, since it also shells out to "p4" via StreamDepth/cmdAndScan)
+// so the comparison below is apples-to-apples at the parsing layer for all three formats.
+
+const benchmarkCorpusSize = 1_000_000
+
+func syntheticRecord(i int) map[string]string {
+	return map[string]string{
+		"depotFile":  fmt.Sprintf("//depot/main/Engine/Source/File%d.cpp", i),
+		"headAction": "edit",
+		"headChange": strconv.Itoa(1000 + i),
+		"headType":   "text",
+		"digest":     fmt.Sprintf("%032X", i),
+	}
+}
+
+func buildTextCorpus(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		rec := syntheticRecord(i)
+		fmt.Fprintf(&buf, "... depotFile %s\n", rec["depotFile"])
+		fmt.Fprintf(&buf, "... headAction %s\n", rec["headAction"])
+		fmt.Fprintf(&buf, "... headChange %s\n", rec["headChange"])
+		fmt.Fprintf(&buf, "... headType %s\n", rec["headType"])
+		fmt.Fprintf(&buf, "... digest %s\n", rec["digest"])
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+func buildMarshalCorpus(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		encodeMarshalDict(&buf, syntheticRecord(i))
+	}
+	return buf.Bytes()
+}
+
+func buildJSONCorpus(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		rec := syntheticRecord(i)
+		fmt.Fprintf(&buf, `{"depotFile":%q,"headAction":%q,"headChange":%q,"headType":%q,"digest":%q}`+"\n",
+			rec["depotFile"], rec["headAction"], rec["headChange"], rec["headType"], rec["digest"])
+	}
+	return buf.Bytes()
+}
+
+// parseTextRecords replicates the "... <tag> <value>" scanning done by
+// runAndParseDepotFilesText, for benchmarking the line-oriented approach in isolation.
+func parseTextRecords(data []byte) (int, error) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	var cur DepotFile
+	count := 0
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 {
+			if len(cur.Path) != 0 {
+				count++
+			}
+			cur = DepotFile{}
+			continue
+		}
+		if len(line) < 5 || !strings.HasPrefix(line, "... ") {
+			return 0, fmt.Errorf("expected '... <tag>', but got: %s", line)
+		}
+		switch {
+		case strings.HasPrefix(line[4:], "depotFile"):
+			cur.Path = strings.TrimSpace(line[14:])
+		case strings.HasPrefix(line[4:], "headAction"):
+			cur.Action = strings.TrimSpace(line[14:])
+		case strings.HasPrefix(line[4:], "headChange"):
+			cur.CL = strings.TrimSpace(line[14:])
+		case strings.HasPrefix(line[4:], "headType"):
+			cur.Type = strings.TrimSpace(line[12:])
+		case strings.HasPrefix(line[4:], "digest"):
+			cur.Digest = strings.TrimSpace(line[10:])
+		}
+	}
+	return count, s.Err()
+}
+
+func parseMarshalRecords(data []byte) (int, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	count := 0
+	for {
+		_, err := decodeMarshalDict(r)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+func parseJSONRecords(data []byte) (int, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	count := 0
+	for {
+		_, err := decodeJSONDict(r)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+func BenchmarkParseDepotFilesText(b *testing.B) {
+	data := buildTextCorpus(benchmarkCorpusSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseTextRecords(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseDepotFilesPyMarshal(b *testing.B) {
+	data := buildMarshalCorpus(benchmarkCorpusSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseMarshalRecords(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseDepotFilesJSON(b *testing.B) {
+	data := buildJSONCorpus(benchmarkCorpusSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseJSONRecords(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}